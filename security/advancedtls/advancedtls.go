@@ -0,0 +1,667 @@
+/*
+ *
+ * Copyright 2020 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package advancedtls contains testing only utility functions to provide
+// more advanced TLS/SSL facilities, e.g. reload identity/root credentials,
+// allow customized cert/server verification, etc.
+package advancedtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.org/x/crypto/ocsp"
+	"google.golang.org/grpc/credentials"
+)
+
+// VerificationType is the enum type for the different levels of certificate
+// verification advancedtls provides.
+type VerificationType int
+
+const (
+	// CertAndHostVerification indicates doing both certificate signature
+	// check and hostname check.
+	CertAndHostVerification VerificationType = iota
+	// CertVerification indicates doing certificate signature check only. This
+	// cannot be used with the default verifier from Go's standard library
+	// because the hostname check is always performed by it.
+	CertVerification
+	// SkipVerification indicates skipping both certificate signature check
+	// and hostname check.
+	SkipVerification
+)
+
+// GetRootCAsParams contains the parameters available to the
+// GetRootCertificates callback.
+type GetRootCAsParams struct {
+	RawConn        net.Conn
+	RawCerts       [][]byte
+	VerifiedChains [][]*x509.Certificate
+	Leaf           *x509.Certificate
+}
+
+// GetRootCAsResults contains the results of the GetRootCertificates callback.
+type GetRootCAsResults struct {
+	TrustCerts *x509.CertPool
+}
+
+// VerificationFuncParams contains the parameters available to a
+// CustomVerificationFunc.
+type VerificationFuncParams struct {
+	RawConn        net.Conn
+	RawCerts       [][]byte
+	VerifiedChains [][]*x509.Certificate
+	Leaf           *x509.Certificate
+	// RevocationStatus is the result of the revocation check configured via
+	// RevocationConfig, performed just before VerifyPeer runs. It is
+	// RevocationUndetermined if no RevocationConfig was set.
+	RevocationStatus RevocationStatus
+}
+
+// RevocationStatus is the outcome of checking a certificate chain against
+// CRLs and/or stapled OCSP responses.
+type RevocationStatus int
+
+const (
+	// RevocationUndetermined means revocation was either not configured, or
+	// no source (CRL/OCSP) could affirmatively vouch for every certificate
+	// in the chain.
+	RevocationUndetermined RevocationStatus = iota
+	// RevocationGood means every certificate in the chain (other than the
+	// root) was checked against a CRL or OCSP response and found not
+	// revoked.
+	RevocationGood
+	// RevocationRevoked means at least one certificate in the chain was
+	// found revoked.
+	RevocationRevoked
+)
+
+// RevocationConfig contains options for checking a peer's certificate chain
+// against CRLs and/or OCSP responses, in addition to the certificate
+// signature check advancedtls already performs.
+type RevocationConfig struct {
+	// CRLProvider, if set, is called with the issuer of each certificate in
+	// the peer's chain (other than the root) to obtain the CRL that would
+	// cover it.
+	CRLProvider func(issuer *x509.Certificate) (*pkix.CertificateList, error)
+	// OCSPResponder, if set, is called with a certificate and its issuer to
+	// obtain an OCSP response for it. It is consulted when CRLProvider is
+	// unset, or returns an error, for a given certificate.
+	OCSPResponder func(cert, issuer *x509.Certificate) (*ocsp.Response, error)
+	// AllowStapledOCSP, if true, also checks the OCSP response stapled to
+	// the TLS handshake once the handshake has completed: if it marks the
+	// peer's leaf certificate revoked, the connection is rejected. The
+	// stapled response is not consulted during the chain check, and does not
+	// affect the RevocationStatus passed to VerifyPeer.
+	AllowStapledOCSP bool
+	// SoftFail, if true, treats a RevocationUndetermined outcome (neither
+	// CRL nor OCSP could vouch for a certificate) as acceptable, instead of
+	// failing the handshake.
+	SoftFail bool
+}
+
+// VerificationResults contains the results of a CustomVerificationFunc. It is
+// currently empty, but may be extended in the future.
+type VerificationResults struct{}
+
+// CustomVerificationFunc is the function type of a custom verification
+// check, performed after the certificate signature check has completed.
+type CustomVerificationFunc func(params *VerificationFuncParams) (*VerificationResults, error)
+
+// RootCertificateOptions contains options to obtain root trust certificates
+// for both the client and the server.
+// At most one option could be set. If none of them are set, we
+// use the system default trust certificates.
+type RootCertificateOptions struct {
+	// RootCACerts is the pool of CAs trusted by this endpoint. If set, no
+	// reloading of the root certificates happens.
+	RootCACerts *x509.CertPool
+	// GetRootCertificates is the custom function used by the endpoint to
+	// obtain root trust certificates for validating the peer's certificate.
+	// It cannot be set together with RootCACerts.
+	GetRootCertificates func(params *GetRootCAsParams) (*GetRootCAsResults, error)
+}
+
+func (o RootCertificateOptions) validate() error {
+	if o.RootCACerts != nil && o.GetRootCertificates != nil {
+		return fmt.Errorf("at most one of RootCACerts and GetRootCertificates can be specified")
+	}
+	return nil
+}
+
+// IdentityCertificateOptions contains options to obtain identity
+// certificates for both the client and the server.
+type IdentityCertificateOptions struct {
+	// Certificates is the slice containing the endpoint's own identity
+	// certificate(s). If set, no reloading of the identity certificate(s)
+	// happens.
+	Certificates []tls.Certificate
+	// GetIdentityCertificatesForClient, if set, is used by the client to
+	// obtain identity certificates. It cannot be set together with
+	// Certificates.
+	GetIdentityCertificatesForClient func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+	// GetIdentityCertificatesForServer, if set, is used by the server to
+	// obtain identity certificates. It cannot be set together with
+	// Certificates.
+	GetIdentityCertificatesForServer func(*tls.ClientHelloInfo) ([]*tls.Certificate, error)
+}
+
+func (o IdentityCertificateOptions) validateForClient() error {
+	if len(o.Certificates) != 0 && o.GetIdentityCertificatesForClient != nil {
+		return fmt.Errorf("at most one of Certificates and GetIdentityCertificatesForClient can be specified")
+	}
+	return nil
+}
+
+func (o IdentityCertificateOptions) validateForServer() error {
+	if len(o.Certificates) != 0 && o.GetIdentityCertificatesForServer != nil {
+		return fmt.Errorf("at most one of Certificates and GetIdentityCertificatesForServer can be specified")
+	}
+	return nil
+}
+
+// ClientOptions contains the fields a client needs to fill to create
+// client-side credentials using advancedtls.
+type ClientOptions struct {
+	// IdentityOptions is OPTIONAL. It sets the client's identity certificate.
+	IdentityOptions IdentityCertificateOptions
+	// VerifyPeer, if set, is the custom verification check performed after
+	// the certificate signature check.
+	VerifyPeer CustomVerificationFunc
+	// RootOptions is OPTIONAL. It sets the CA certificates used to validate
+	// the server's certificate.
+	RootOptions RootCertificateOptions
+	// VType is the verification type this client is going to use.
+	VType VerificationType
+	// ServerNameOverride, if set, overrides the virtual host name expected
+	// by the client for server authentication purposes.
+	ServerNameOverride string
+	// CipherSuites, if set, is the list of cipher suites supported. If nil,
+	// the default cipher suite list is used.
+	CipherSuites []uint16
+	// RevocationOptions, if set, additionally checks the server's
+	// certificate chain against CRLs and/or OCSP responses.
+	RevocationOptions *RevocationConfig
+}
+
+func (o *ClientOptions) config() (*tls.Config, error) {
+	if err := o.IdentityOptions.validateForClient(); err != nil {
+		return nil, err
+	}
+	if err := o.RootOptions.validate(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		ServerName:           o.ServerNameOverride,
+		Certificates:         o.IdentityOptions.Certificates,
+		GetClientCertificate: o.IdentityOptions.GetIdentityCertificatesForClient,
+		RootCAs:              o.RootOptions.RootCACerts,
+		CipherSuites:         o.CipherSuites,
+		InsecureSkipVerify:   true,
+	}, nil
+}
+
+// ClientAuthType is the Go mirror of tls.ClientAuthType, letting a server
+// pick any point on the standard library's client-auth spectrum instead of
+// the on/off choice RequireClientCert offered.
+type ClientAuthType int
+
+const (
+	// NoClientCert indicates the server should not request a certificate
+	// from the client, and cannot require it.
+	NoClientCert ClientAuthType = iota
+	// RequestClientCert indicates the server should request a certificate
+	// from the client, but will not require that the client present it,
+	// nor will it be verified if presented.
+	RequestClientCert
+	// RequireAnyClientCert indicates the server should require a
+	// certificate from the client, but will not verify it.
+	RequireAnyClientCert
+	// VerifyClientCertIfGiven indicates the server should request a
+	// certificate from the client, but will not require it. If the client
+	// does present one, it will be verified.
+	VerifyClientCertIfGiven
+	// RequireAndVerifyClientCert indicates the server should require a
+	// certificate from the client, and will verify it.
+	RequireAndVerifyClientCert
+)
+
+// ServerOptions contains the fields a server needs to fill to create
+// server-side credentials using advancedtls.
+type ServerOptions struct {
+	// IdentityOptions is REQUIRED. It sets the server's identity
+	// certificate(s).
+	IdentityOptions IdentityCertificateOptions
+	// RootOptions is OPTIONAL. It sets the CA certificates used to validate
+	// the client's certificate, and is only used when client certificates
+	// are requested.
+	RootOptions RootCertificateOptions
+	// RequireClientCert indicates whether the server should require the
+	// client to present a certificate. This is a legacy, less expressive
+	// alternative to ClientAuth, kept only for backwards compatibility: it
+	// is equivalent to setting ClientAuth to RequireAndVerifyClientCert.
+	//
+	// Deprecated: use ClientAuth instead.
+	RequireClientCert bool
+	// ClientAuth sets the server's policy for requiring and verifying the
+	// client's certificate. If left at its zero value (NoClientCert) and
+	// RequireClientCert is true, RequireAndVerifyClientCert is used
+	// instead.
+	ClientAuth ClientAuthType
+	// VerifyPeer, if set, is the custom verification check performed after
+	// the certificate signature check. When ClientAuth allows a client to
+	// omit its certificate (RequestClientCert or VerifyClientCertIfGiven),
+	// VerificationFuncParams.RawCerts may legitimately be empty.
+	VerifyPeer CustomVerificationFunc
+	// VType is the verification type this server is going to use.
+	VType VerificationType
+	// CipherSuites, if set, is the list of cipher suites supported. If nil,
+	// the default cipher suite list is used.
+	CipherSuites []uint16
+	// RevocationOptions, if set, additionally checks the client's
+	// certificate chain against CRLs and/or OCSP responses.
+	RevocationOptions *RevocationConfig
+}
+
+// clientAuth resolves the effective ClientAuthType, honoring the deprecated
+// RequireClientCert for callers who have not migrated to ClientAuth yet.
+func (o *ServerOptions) clientAuth() ClientAuthType {
+	if o.ClientAuth == NoClientCert && o.RequireClientCert {
+		return RequireAndVerifyClientCert
+	}
+	return o.ClientAuth
+}
+
+func (o *ServerOptions) config() (*tls.Config, error) {
+	if err := o.IdentityOptions.validateForServer(); err != nil {
+		return nil, err
+	}
+	if err := o.RootOptions.validate(); err != nil {
+		return nil, err
+	}
+	// advancedtls always performs certificate-chain verification itself
+	// (via VerifyPeerCertificate, see buildVerifyFunc), so that root
+	// certificates can be resolved dynamically through GetRootCertificates.
+	// We therefore only ever ask the standard library to request/require
+	// presence of a client certificate, never to verify it, regardless of
+	// which ClientAuthType the caller selected; whether a presented
+	// certificate is actually verified, or merely required/requested, is
+	// decided by buildVerifiedChains according to the resolved ClientAuth.
+	var stdlibClientAuth tls.ClientAuthType
+	switch o.clientAuth() {
+	case NoClientCert:
+		stdlibClientAuth = tls.NoClientCert
+	case RequestClientCert, VerifyClientCertIfGiven:
+		stdlibClientAuth = tls.RequestClientCert
+	case RequireAnyClientCert, RequireAndVerifyClientCert:
+		stdlibClientAuth = tls.RequireAnyClientCert
+	}
+	return &tls.Config{
+		ClientAuth:         stdlibClientAuth,
+		Certificates:       o.IdentityOptions.Certificates,
+		GetCertificate:     getCertificate(o.IdentityOptions.GetIdentityCertificatesForServer),
+		ClientCAs:          o.RootOptions.RootCACerts,
+		CipherSuites:       o.CipherSuites,
+		InsecureSkipVerify: true,
+	}, nil
+}
+
+func getCertificate(f func(*tls.ClientHelloInfo) ([]*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if f == nil {
+		return nil
+	}
+	return func(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		certs, err := f(info)
+		if err != nil {
+			return nil, err
+		}
+		if len(certs) == 0 {
+			return nil, fmt.Errorf("advancedtls: GetIdentityCertificatesForServer returned no certificates")
+		}
+		return certs[0], nil
+	}
+}
+
+// advancedTLSCreds implements the credentials.TransportCredentials interface
+// using advancedtls's pluggable root/identity and custom verification
+// facilities.
+type advancedTLSCreds struct {
+	config           *tls.Config
+	isClient         bool
+	verifyFunc       CustomVerificationFunc
+	getRootCAs       func(params *GetRootCAsParams) (*GetRootCAsResults, error)
+	vType            VerificationType
+	revocationConfig *RevocationConfig
+	// clientAuthType is the resolved ClientAuthType from ServerOptions. It
+	// is always NoClientCert on the client side, where the concept does not
+	// apply.
+	clientAuthType ClientAuthType
+}
+
+func (c *advancedTLSCreds) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{
+		SecurityProtocol: "tls",
+		ServerName:       c.config.ServerName,
+	}
+}
+
+func (c *advancedTLSCreds) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	cfg := c.config.Clone()
+	cfg.ServerName = authority
+	if c.config.ServerName != "" {
+		cfg.ServerName = c.config.ServerName
+	}
+	var verifiedChains [][]*x509.Certificate
+	cfg.VerifyPeerCertificate = buildVerifyFunc(c, rawConn, &verifiedChains)
+	conn := tls.Client(rawConn, cfg)
+	errChannel := make(chan error, 1)
+	go func() {
+		errChannel <- conn.Handshake()
+	}()
+	select {
+	case err := <-errChannel:
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	case <-ctx.Done():
+		conn.Close()
+		return nil, nil, ctx.Err()
+	}
+	state := conn.ConnectionState()
+	if err := checkStapledOCSP(c.revocationConfig, state.OCSPResponse, verifiedChains); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, credentials.TLSInfo{State: state}, nil
+}
+
+func (c *advancedTLSCreds) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	cfg := c.config.Clone()
+	var verifiedChains [][]*x509.Certificate
+	cfg.VerifyPeerCertificate = buildVerifyFunc(c, rawConn, &verifiedChains)
+	conn := tls.Server(rawConn, cfg)
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	state := conn.ConnectionState()
+	if err := checkStapledOCSP(c.revocationConfig, state.OCSPResponse, verifiedChains); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, credentials.TLSInfo{State: state}, nil
+}
+
+// checkStapledOCSP rejects the connection if RevocationConfig.AllowStapledOCSP
+// is set and ocspResponse affirmatively marks chains[0]'s leaf certificate as
+// revoked. ocspResponse and chains must come from the same handshake: the
+// stapled response off of tls.ConnectionState, and the chain buildVerifyFunc
+// built for the peer's leaf certificate during that same handshake (stdlib
+// never populates ConnectionState.VerifiedChains itself, since advancedtls
+// always sets InsecureSkipVerify).
+func checkStapledOCSP(cfg *RevocationConfig, ocspResponse []byte, chains [][]*x509.Certificate) error {
+	if cfg == nil || !cfg.AllowStapledOCSP || len(ocspResponse) == 0 || len(chains) == 0 {
+		return nil
+	}
+	chain := chains[0]
+	if len(chain) < 2 {
+		return nil
+	}
+	resp, err := ocsp.ParseResponse(ocspResponse, chain[1])
+	if err != nil {
+		if !cfg.SoftFail {
+			return fmt.Errorf("advancedtls: failed to parse stapled OCSP response: %v", err)
+		}
+		return nil
+	}
+	if resp.Status == ocsp.Revoked {
+		return fmt.Errorf("advancedtls: certificate serial %s is revoked per stapled OCSP response", chain[0].SerialNumber)
+	}
+	return nil
+}
+
+// buildVerifyFunc returns a tls.Config.VerifyPeerCertificate implementation
+// that performs the chain and revocation checks, and additionally writes the
+// chain it verified to *verifiedChains, so that the caller can consult it
+// after the handshake completes (see checkStapledOCSP).
+func buildVerifyFunc(c *advancedTLSCreds, rawConn net.Conn, verifiedChains *[][]*x509.Certificate) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		chains, err := buildVerifiedChains(c, rawConn, rawCerts)
+		if err != nil {
+			return err
+		}
+		*verifiedChains = chains
+		var leaf *x509.Certificate
+		if len(chains) > 0 && len(chains[0]) > 0 {
+			leaf = chains[0][0]
+		}
+		status := RevocationUndetermined
+		if c.revocationConfig != nil && len(chains) > 0 {
+			var revErr error
+			status, revErr = checkRevocation(chains[0], c.revocationConfig)
+			if revErr != nil {
+				return revErr
+			}
+		}
+		if c.verifyFunc != nil {
+			_, err := c.verifyFunc(&VerificationFuncParams{
+				RawConn:          rawConn,
+				RawCerts:         rawCerts,
+				VerifiedChains:   chains,
+				Leaf:             leaf,
+				RevocationStatus: status,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// checkRevocation checks every certificate in chain, other than the root,
+// against cfg.CRLProvider and then cfg.OCSPResponder. It returns
+// RevocationRevoked and an error as soon as any certificate is found
+// revoked. If a certificate can't be vouched for by either source, it
+// returns RevocationUndetermined and, unless cfg.SoftFail is set, an error
+// — unless cfg.AllowStapledOCSP is set and neither CRLProvider nor
+// OCSPResponder is configured, in which case the stapled OCSP response is
+// the only revocation source the caller asked for, and it isn't available
+// until after the handshake completes (see checkStapledOCSP); this defers
+// to that later check, reporting RevocationUndetermined without an error,
+// instead of failing the handshake before the stapled response can ever be
+// consulted.
+func checkRevocation(chain []*x509.Certificate, cfg *RevocationConfig) (RevocationStatus, error) {
+	deferredToStapledOCSP := false
+	undetermined := false
+	for i := 0; i < len(chain)-1; i++ {
+		cert, issuer := chain[i], chain[i+1]
+		checked, revoked, err := checkCertRevocation(cert, issuer, cfg)
+		if revoked {
+			return RevocationRevoked, fmt.Errorf("advancedtls: certificate serial %s is revoked", cert.SerialNumber)
+		}
+		if checked {
+			continue
+		}
+		if cfg.AllowStapledOCSP && cfg.CRLProvider == nil && cfg.OCSPResponder == nil {
+			deferredToStapledOCSP = true
+			continue
+		}
+		if err != nil && !cfg.SoftFail {
+			return RevocationUndetermined, fmt.Errorf("advancedtls: failed to determine revocation status for certificate serial %s: %v", cert.SerialNumber, err)
+		}
+		if !cfg.SoftFail {
+			return RevocationUndetermined, fmt.Errorf("advancedtls: unable to determine revocation status for certificate serial %s", cert.SerialNumber)
+		}
+		undetermined = true
+	}
+	if deferredToStapledOCSP || undetermined {
+		return RevocationUndetermined, nil
+	}
+	return RevocationGood, nil
+}
+
+// checkCertRevocation consults cfg.CRLProvider, then cfg.OCSPResponder, for
+// cert. checked is true if one of them returned a usable answer (in which
+// case revoked reports that answer); checked is false if neither could, in
+// which case err, if non-nil, is the most recent lookup failure.
+func checkCertRevocation(cert, issuer *x509.Certificate, cfg *RevocationConfig) (checked, revoked bool, err error) {
+	if cfg.CRLProvider != nil {
+		crl, crlErr := cfg.CRLProvider(issuer)
+		if crlErr == nil && crl != nil {
+			if sigErr := issuer.CheckCRLSignature(crl); sigErr != nil {
+				err = fmt.Errorf("CRL is not validly signed by issuer %q: %v", issuer.Subject, sigErr)
+			} else {
+				for _, r := range crl.TBSCertList.RevokedCertificates {
+					if r.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+						return true, true, nil
+					}
+				}
+				return true, false, nil
+			}
+		} else {
+			err = crlErr
+		}
+	}
+	if cfg.OCSPResponder != nil {
+		resp, ocspErr := cfg.OCSPResponder(cert, issuer)
+		if ocspErr == nil && resp != nil {
+			return true, resp.Status == ocsp.Revoked, nil
+		}
+		err = ocspErr
+	}
+	return false, false, err
+}
+
+// buildVerifiedChains performs the certificate signature check according to
+// c.vType, using either the static root pool or the result of
+// c.getRootCAs, and returns the verified chains. On the server side, if
+// c.clientAuthType is RequestClientCert or RequireAnyClientCert, the client
+// certificate is parsed but deliberately left unverified, matching those
+// ClientAuthTypes' documented semantics.
+func buildVerifiedChains(c *advancedTLSCreds, rawConn net.Conn, rawCerts [][]byte) ([][]*x509.Certificate, error) {
+	if c.vType == SkipVerification || len(rawCerts) == 0 {
+		return nil, nil
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, asn1Data := range rawCerts {
+		cert, err := x509.ParseCertificate(asn1Data)
+		if err != nil {
+			return nil, fmt.Errorf("advancedtls: failed to parse certificate: %v", err)
+		}
+		certs[i] = cert
+	}
+	if !c.isClient && (c.clientAuthType == RequestClientCert || c.clientAuthType == RequireAnyClientCert) {
+		return [][]*x509.Certificate{certs}, nil
+	}
+	roots := c.config.RootCAs
+	if c.isClient {
+		roots = c.config.RootCAs
+	} else {
+		roots = c.config.ClientCAs
+	}
+	if c.getRootCAs != nil {
+		results, err := c.getRootCAs(&GetRootCAsParams{
+			RawConn:  rawConn,
+			RawCerts: rawCerts,
+			Leaf:     certs[0],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("advancedtls: failed to get root certificates: %v", err)
+		}
+		roots = results.TrustCerts
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	if c.isClient {
+		opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	} else {
+		opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	return certs[0].Verify(opts)
+}
+
+func (c *advancedTLSCreds) Clone() credentials.TransportCredentials {
+	creds := &advancedTLSCreds{
+		config:           c.config.Clone(),
+		isClient:         c.isClient,
+		verifyFunc:       c.verifyFunc,
+		getRootCAs:       c.getRootCAs,
+		vType:            c.vType,
+		revocationConfig: c.revocationConfig,
+		clientAuthType:   c.clientAuthType,
+	}
+	return creds
+}
+
+func (c *advancedTLSCreds) OverrideServerName(name string) error {
+	c.config.ServerName = name
+	return nil
+}
+
+// NewClientCreds uses ClientOptions to construct a TransportCredentials
+// based on TLS for a client.
+func NewClientCreds(o *ClientOptions) (credentials.TransportCredentials, error) {
+	if o == nil {
+		return nil, errors.New("advancedtls: ClientOptions is nil")
+	}
+	cfg, err := o.config()
+	if err != nil {
+		return nil, err
+	}
+	return &advancedTLSCreds{
+		config:           cfg,
+		isClient:         true,
+		verifyFunc:       o.VerifyPeer,
+		getRootCAs:       o.RootOptions.GetRootCertificates,
+		vType:            o.VType,
+		revocationConfig: o.RevocationOptions,
+	}, nil
+}
+
+// NewServerCreds uses ServerOptions to construct a TransportCredentials
+// based on TLS for a server.
+func NewServerCreds(o *ServerOptions) (credentials.TransportCredentials, error) {
+	if o == nil {
+		return nil, errors.New("advancedtls: ServerOptions is nil")
+	}
+	cfg, err := o.config()
+	if err != nil {
+		return nil, err
+	}
+	return &advancedTLSCreds{
+		config:           cfg,
+		isClient:         false,
+		verifyFunc:       o.VerifyPeer,
+		getRootCAs:       o.RootOptions.GetRootCertificates,
+		vType:            o.VType,
+		revocationConfig: o.RevocationOptions,
+		clientAuthType:   o.clientAuth(),
+	}, nil
+}
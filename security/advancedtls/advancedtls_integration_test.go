@@ -408,3 +408,240 @@ func (s) TestEnd2End(t *testing.T) {
 		})
 	}
 }
+
+// TestServerVerifyClientCertIfGiven exercises
+// ServerOptions.ClientAuth = VerifyClientCertIfGiven: at stage 0, the client
+// presents no identity certificate at all, and the server must still accept
+// the connection. At stage 1, the client presents ClientCert1, and the
+// server must validate it using ServerTrust1 before accepting the
+// connection.
+func (s) TestServerVerifyClientCertIfGiven(t *testing.T) {
+	cs := &testutils.CertStore{}
+	if err := cs.LoadCerts(); err != nil {
+		t.Fatalf("cs.LoadCerts() failed, err: %v", err)
+	}
+	serverOptions := &ServerOptions{
+		IdentityOptions: IdentityCertificateOptions{
+			Certificates: []tls.Certificate{cs.ServerCert1},
+		},
+		RootOptions: RootCertificateOptions{
+			RootCACerts: cs.ServerTrust1,
+		},
+		ClientAuth: VerifyClientCertIfGiven,
+		VerifyPeer: func(params *VerificationFuncParams) (*VerificationResults, error) {
+			return &VerificationResults{}, nil
+		},
+		VType: CertVerification,
+	}
+	serverTLSCreds, err := NewServerCreds(serverOptions)
+	if err != nil {
+		t.Fatalf("failed to create server creds: %v", err)
+	}
+	s := grpc.NewServer(grpc.Creds(serverTLSCreds))
+	defer s.Stop()
+	lis, err := net.Listen("tcp", port)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer lis.Close()
+	pb.RegisterGreeterServer(s, greeterServer{})
+	go s.Serve(lis)
+
+	clientVerifyFunc := func(params *VerificationFuncParams) (*VerificationResults, error) {
+		return &VerificationResults{}, nil
+	}
+
+	// Stage 0: the client presents no identity certificate.
+	noCertCreds, err := NewClientCreds(&ClientOptions{
+		RootOptions: RootCertificateOptions{RootCACerts: cs.ClientTrust1},
+		VerifyPeer:  clientVerifyFunc,
+		VType:       CertVerification,
+	})
+	if err != nil {
+		t.Fatalf("clientTLSCreds failed to create: %v", err)
+	}
+	ctx0, cancel0 := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel0()
+	conn0, _, err := callAndVerifyWithClientConn(ctx0, "rpc call without client cert", noCertCreds, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn0.Close()
+
+	// Stage 1: the client presents ClientCert1, which ServerTrust1 trusts.
+	withCertCreds, err := NewClientCreds(&ClientOptions{
+		IdentityOptions: IdentityCertificateOptions{Certificates: []tls.Certificate{cs.ClientCert1}},
+		RootOptions:     RootCertificateOptions{RootCACerts: cs.ClientTrust1},
+		VerifyPeer:      clientVerifyFunc,
+		VType:           CertVerification,
+	})
+	if err != nil {
+		t.Fatalf("clientTLSCreds failed to create: %v", err)
+	}
+	ctx1, cancel1 := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel1()
+	conn1, _, err := callAndVerifyWithClientConn(ctx1, "rpc call with client cert", withCertCreds, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn1.Close()
+}
+
+// TestServerRequireAnyClientCert exercises
+// ServerOptions.ClientAuth = RequireAnyClientCert: a client that presents no
+// certificate must be rejected, but a client that presents a certificate
+// ServerTrust1 does not trust (ClientCert2) must still be accepted, since
+// RequireAnyClientCert requires a certificate without verifying it.
+func (s) TestServerRequireAnyClientCert(t *testing.T) {
+	cs := &testutils.CertStore{}
+	if err := cs.LoadCerts(); err != nil {
+		t.Fatalf("cs.LoadCerts() failed, err: %v", err)
+	}
+	serverOptions := &ServerOptions{
+		IdentityOptions: IdentityCertificateOptions{
+			Certificates: []tls.Certificate{cs.ServerCert1},
+		},
+		RootOptions: RootCertificateOptions{
+			RootCACerts: cs.ServerTrust1,
+		},
+		ClientAuth: RequireAnyClientCert,
+		VerifyPeer: func(params *VerificationFuncParams) (*VerificationResults, error) {
+			return &VerificationResults{}, nil
+		},
+		VType: CertVerification,
+	}
+	serverTLSCreds, err := NewServerCreds(serverOptions)
+	if err != nil {
+		t.Fatalf("failed to create server creds: %v", err)
+	}
+	s := grpc.NewServer(grpc.Creds(serverTLSCreds))
+	defer s.Stop()
+	lis, err := net.Listen("tcp", port)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer lis.Close()
+	pb.RegisterGreeterServer(s, greeterServer{})
+	go s.Serve(lis)
+
+	clientVerifyFunc := func(params *VerificationFuncParams) (*VerificationResults, error) {
+		return &VerificationResults{}, nil
+	}
+
+	// Stage 0: the client presents no identity certificate, which
+	// RequireAnyClientCert does not allow.
+	noCertCreds, err := NewClientCreds(&ClientOptions{
+		RootOptions: RootCertificateOptions{RootCACerts: cs.ClientTrust1},
+		VerifyPeer:  clientVerifyFunc,
+		VType:       CertVerification,
+	})
+	if err != nil {
+		t.Fatalf("clientTLSCreds failed to create: %v", err)
+	}
+	ctx0, cancel0 := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel0()
+	conn0, _, err := callAndVerifyWithClientConn(ctx0, "rpc call without client cert", noCertCreds, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn0.Close()
+
+	// Stage 1: the client presents ClientCert2, which ServerTrust1 does not
+	// trust; the connection must still succeed because RequireAnyClientCert
+	// does not verify the certificate it requires.
+	untrustedCertCreds, err := NewClientCreds(&ClientOptions{
+		IdentityOptions: IdentityCertificateOptions{Certificates: []tls.Certificate{cs.ClientCert2}},
+		RootOptions:     RootCertificateOptions{RootCACerts: cs.ClientTrust1},
+		VerifyPeer:      clientVerifyFunc,
+		VType:           CertVerification,
+	})
+	if err != nil {
+		t.Fatalf("clientTLSCreds failed to create: %v", err)
+	}
+	ctx1, cancel1 := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel1()
+	conn1, _, err := callAndVerifyWithClientConn(ctx1, "rpc call with untrusted client cert", untrustedCertCreds, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn1.Close()
+}
+
+// TestServerRequestClientCert exercises
+// ServerOptions.ClientAuth = RequestClientCert: a client that presents no
+// certificate must be accepted, and so must a client that presents a
+// certificate ServerTrust1 does not trust (ClientCert2), since
+// RequestClientCert neither requires nor verifies the client certificate.
+func (s) TestServerRequestClientCert(t *testing.T) {
+	cs := &testutils.CertStore{}
+	if err := cs.LoadCerts(); err != nil {
+		t.Fatalf("cs.LoadCerts() failed, err: %v", err)
+	}
+	serverOptions := &ServerOptions{
+		IdentityOptions: IdentityCertificateOptions{
+			Certificates: []tls.Certificate{cs.ServerCert1},
+		},
+		RootOptions: RootCertificateOptions{
+			RootCACerts: cs.ServerTrust1,
+		},
+		ClientAuth: RequestClientCert,
+		VerifyPeer: func(params *VerificationFuncParams) (*VerificationResults, error) {
+			return &VerificationResults{}, nil
+		},
+		VType: CertVerification,
+	}
+	serverTLSCreds, err := NewServerCreds(serverOptions)
+	if err != nil {
+		t.Fatalf("failed to create server creds: %v", err)
+	}
+	s := grpc.NewServer(grpc.Creds(serverTLSCreds))
+	defer s.Stop()
+	lis, err := net.Listen("tcp", port)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer lis.Close()
+	pb.RegisterGreeterServer(s, greeterServer{})
+	go s.Serve(lis)
+
+	clientVerifyFunc := func(params *VerificationFuncParams) (*VerificationResults, error) {
+		return &VerificationResults{}, nil
+	}
+
+	// Stage 0: the client presents no identity certificate.
+	noCertCreds, err := NewClientCreds(&ClientOptions{
+		RootOptions: RootCertificateOptions{RootCACerts: cs.ClientTrust1},
+		VerifyPeer:  clientVerifyFunc,
+		VType:       CertVerification,
+	})
+	if err != nil {
+		t.Fatalf("clientTLSCreds failed to create: %v", err)
+	}
+	ctx0, cancel0 := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel0()
+	conn0, _, err := callAndVerifyWithClientConn(ctx0, "rpc call without client cert", noCertCreds, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn0.Close()
+
+	// Stage 1: the client presents ClientCert2, which ServerTrust1 does not
+	// trust; the connection must still succeed because RequestClientCert
+	// does not verify whatever certificate it is given.
+	untrustedCertCreds, err := NewClientCreds(&ClientOptions{
+		IdentityOptions: IdentityCertificateOptions{Certificates: []tls.Certificate{cs.ClientCert2}},
+		RootOptions:     RootCertificateOptions{RootCACerts: cs.ClientTrust1},
+		VerifyPeer:      clientVerifyFunc,
+		VType:           CertVerification,
+	})
+	if err != nil {
+		t.Fatalf("clientTLSCreds failed to create: %v", err)
+	}
+	ctx1, cancel1 := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel1()
+	conn1, _, err := callAndVerifyWithClientConn(ctx1, "rpc call with untrusted client cert", untrustedCertCreds, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn1.Close()
+}
@@ -0,0 +1,250 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package pemfile provides a built-in advancedtls.IdentityCertificateOptions
+// and advancedtls.RootCertificateOptions implementation that watches PEM
+// files on disk and reloads them on change, so callers no longer need to
+// hand-write their own GetIdentityCertificatesForServer/GetRootCertificates
+// callbacks to pick up rotated certificates.
+package pemfile
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/security/advancedtls"
+)
+
+// PEMFileProviderOptions configures a Provider that watches identity and/or
+// root certificate files on disk.
+type PEMFileProviderOptions struct {
+	// IdentityCertFile and IdentityKeyFile, if both set, are the PEM files
+	// this Provider watches for the endpoint's identity certificate/key
+	// pair.
+	IdentityCertFile string
+	IdentityKeyFile  string
+	// TrustCertFile, if set, is the PEM file this Provider watches for the
+	// trust certificate bundle used to verify the peer.
+	TrustCertFile string
+	// RefreshDuration is the interval at which the provider polls the
+	// watched files for changes (by mtime). If unset, it defaults to 1
+	// hour.
+	RefreshDuration time.Duration
+	// OnReloadError, if set, is invoked whenever a reload attempt fails. The
+	// Provider keeps serving the last-known-good bundle in this case.
+	OnReloadError func(err error)
+}
+
+const defaultRefreshDuration = time.Hour
+
+// Provider watches identity and/or root certificate PEM files on disk and
+// keeps an in-memory, atomically-updated copy of the most recently loaded,
+// valid material.
+type Provider struct {
+	opts PEMFileProviderOptions
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+
+	mu          sync.RWMutex
+	identityMod time.Time
+	keyMod      time.Time
+	trustMod    time.Time
+	identity    *tls.Certificate
+	trustPool   *x509.CertPool
+}
+
+// NewPEMFileProvider creates a Provider that loads the configured files once
+// immediately, and then polls them for changes every RefreshDuration.
+func NewPEMFileProvider(o PEMFileProviderOptions) (*Provider, error) {
+	if o.RefreshDuration <= 0 {
+		o.RefreshDuration = defaultRefreshDuration
+	}
+	watchIdentity := o.IdentityCertFile != "" || o.IdentityKeyFile != ""
+	if watchIdentity && (o.IdentityCertFile == "" || o.IdentityKeyFile == "") {
+		return nil, fmt.Errorf("pemfile: IdentityCertFile and IdentityKeyFile must both be set or both be empty")
+	}
+	p := &Provider{
+		opts:    o,
+		closeCh: make(chan struct{}),
+	}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	p.wg.Add(1)
+	go p.run()
+	return p, nil
+}
+
+func (p *Provider) run() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.opts.RefreshDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closeCh:
+			return
+		case <-ticker.C:
+			if err := p.reload(); err != nil && p.opts.OnReloadError != nil {
+				p.opts.OnReloadError(err)
+			}
+		}
+	}
+}
+
+// reload re-reads any watched file whose mtime has advanced, and swaps in
+// the newly parsed material only if every changed file parses successfully.
+// On any error, the previously loaded, last-known-good bundle is left in
+// place.
+func (p *Provider) reload() error {
+	p.mu.RLock()
+	identityMod, keyMod, trustMod := p.identityMod, p.keyMod, p.trustMod
+	p.mu.RUnlock()
+
+	var (
+		newIdentity     *tls.Certificate
+		newTrustPool    *x509.CertPool
+		identityChanged bool
+		trustChanged    bool
+		newIdentityMod  time.Time
+		newKeyMod       time.Time
+		newTrustMod     time.Time
+	)
+
+	if p.opts.IdentityCertFile != "" {
+		certMod, err := modTime(p.opts.IdentityCertFile)
+		if err != nil {
+			return err
+		}
+		keyMod2, err := modTime(p.opts.IdentityKeyFile)
+		if err != nil {
+			return err
+		}
+		if certMod.After(identityMod) || keyMod2.After(keyMod) {
+			cert, err := tls.LoadX509KeyPair(p.opts.IdentityCertFile, p.opts.IdentityKeyFile)
+			if err != nil {
+				return fmt.Errorf("pemfile: failed to load identity key pair: %v", err)
+			}
+			newIdentity = &cert
+			identityChanged = true
+			newIdentityMod, newKeyMod = certMod, keyMod2
+		}
+	}
+
+	if p.opts.TrustCertFile != "" {
+		mod, err := modTime(p.opts.TrustCertFile)
+		if err != nil {
+			return err
+		}
+		if mod.After(trustMod) {
+			pemBytes, err := os.ReadFile(p.opts.TrustCertFile)
+			if err != nil {
+				return fmt.Errorf("pemfile: failed to read trust cert file: %v", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pemBytes) {
+				return fmt.Errorf("pemfile: failed to parse any certificates from %q", p.opts.TrustCertFile)
+			}
+			newTrustPool = pool
+			trustChanged = true
+			newTrustMod = mod
+		}
+	}
+
+	if !identityChanged && !trustChanged {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if identityChanged {
+		p.identity = newIdentity
+		p.identityMod, p.keyMod = newIdentityMod, newKeyMod
+	}
+	if trustChanged {
+		p.trustPool = newTrustPool
+		p.trustMod = newTrustMod
+	}
+	return nil
+}
+
+func modTime(file string) (time.Time, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("pemfile: failed to stat %q: %v", file, err)
+	}
+	return fi.ModTime(), nil
+}
+
+// Close stops the background refresh goroutine. It is safe to call Close
+// more than once.
+func (p *Provider) Close() {
+	select {
+	case <-p.closeCh:
+	default:
+		close(p.closeCh)
+	}
+	p.wg.Wait()
+}
+
+// KeyMaterial returns the most recently loaded identity certificate, or nil
+// if no IdentityCertFile/IdentityKeyFile was configured.
+func (p *Provider) KeyMaterial() *tls.Certificate {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.identity
+}
+
+// TrustPool returns the most recently loaded trust certificate pool, or nil
+// if no TrustCertFile was configured.
+func (p *Provider) TrustPool() *x509.CertPool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.trustPool
+}
+
+// GetIdentityCertificatesForClient is suitable for use as
+// advancedtls.IdentityCertificateOptions.GetIdentityCertificatesForClient.
+func (p *Provider) GetIdentityCertificatesForClient(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if cert := p.KeyMaterial(); cert != nil {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("pemfile: no identity certificate loaded")
+}
+
+// GetIdentityCertificatesForServer is suitable for use as
+// advancedtls.IdentityCertificateOptions.GetIdentityCertificatesForServer.
+func (p *Provider) GetIdentityCertificatesForServer(*tls.ClientHelloInfo) ([]*tls.Certificate, error) {
+	if cert := p.KeyMaterial(); cert != nil {
+		return []*tls.Certificate{cert}, nil
+	}
+	return nil, fmt.Errorf("pemfile: no identity certificate loaded")
+}
+
+// GetRootCertificates is suitable for use as
+// advancedtls.RootCertificateOptions.GetRootCertificates.
+func (p *Provider) GetRootCertificates(*advancedtls.GetRootCAsParams) (*advancedtls.GetRootCAsResults, error) {
+	if pool := p.TrustPool(); pool != nil {
+		return &advancedtls.GetRootCAsResults{TrustCerts: pool}, nil
+	}
+	return nil, fmt.Errorf("pemfile: no trust certificates loaded")
+}
@@ -0,0 +1,252 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package pemfile
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/security/advancedtls"
+)
+
+// writeSelfSignedPEM generates a fresh self-signed cert/key pair for
+// commonName and writes the PEM-encoded cert and key to certFile/keyFile.
+func writeSelfSignedPEM(t *testing.T, commonName, certFile, keyFile string) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyBytes, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatalf("failed to write cert file: %v", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+}
+
+// TestIdentityCertificateRotation checks that GetIdentityCertificatesForServer
+// reflects identity cert/key updates written to IdentityCertFile and
+// IdentityKeyFile after the Provider has started: it loads cert1, rewrites
+// the on-disk PEM files with cert2, and polls until the provider serves
+// cert2. No server or connection is involved.
+func TestIdentityCertificateRotation(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeSelfSignedPEM(t, "server1.test", certFile, keyFile)
+
+	p, err := NewPEMFileProvider(PEMFileProviderOptions{
+		IdentityCertFile: certFile,
+		IdentityKeyFile:  keyFile,
+		RefreshDuration:  50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewPEMFileProvider() failed: %v", err)
+	}
+	defer p.Close()
+
+	first := p.KeyMaterial()
+	if first == nil {
+		t.Fatalf("KeyMaterial() returned nil after initial load")
+	}
+
+	writeSelfSignedPEM(t, "server2.test", certFile, keyFile)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		certs, err := p.GetIdentityCertificatesForServer(nil)
+		if err != nil {
+			t.Fatalf("GetIdentityCertificatesForServer() failed: %v", err)
+		}
+		leaf, err := x509.ParseCertificate(certs[0].Certificate[0])
+		if err != nil {
+			t.Fatalf("failed to parse reloaded cert: %v", err)
+		}
+		if leaf.Subject.CommonName == "server2.test" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("provider did not pick up rotated cert before deadline")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestRootCertificateRotation checks that GetRootCertificates reflects trust
+// bundle updates written to TrustCertFile after the Provider has started.
+func TestRootCertificateRotation(t *testing.T) {
+	dir := t.TempDir()
+	trustFile := filepath.Join(dir, "trust.pem")
+	caCertFile := filepath.Join(dir, "ca1.pem")
+	caKeyFile := filepath.Join(dir, "ca1.key")
+	writeSelfSignedPEM(t, "ca1", caCertFile, caKeyFile)
+	ca1PEM, err := os.ReadFile(caCertFile)
+	if err != nil {
+		t.Fatalf("failed to read ca1: %v", err)
+	}
+	if err := os.WriteFile(trustFile, ca1PEM, 0600); err != nil {
+		t.Fatalf("failed to write trust file: %v", err)
+	}
+
+	p, err := NewPEMFileProvider(PEMFileProviderOptions{
+		TrustCertFile:   trustFile,
+		RefreshDuration: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewPEMFileProvider() failed: %v", err)
+	}
+	defer p.Close()
+
+	results, err := p.GetRootCertificates(&advancedtls.GetRootCAsParams{})
+	if err != nil {
+		t.Fatalf("GetRootCertificates() failed: %v", err)
+	}
+	if results.TrustCerts == nil {
+		t.Fatalf("GetRootCertificates() returned nil pool")
+	}
+
+	caCertFile2 := filepath.Join(dir, "ca2.pem")
+	caKeyFile2 := filepath.Join(dir, "ca2.key")
+	writeSelfSignedPEM(t, "ca2", caCertFile2, caKeyFile2)
+	ca2PEM, err := os.ReadFile(caCertFile2)
+	if err != nil {
+		t.Fatalf("failed to read ca2: %v", err)
+	}
+	if err := os.WriteFile(trustFile, ca2PEM, 0600); err != nil {
+		t.Fatalf("failed to rewrite trust file: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		results, err := p.GetRootCertificates(&advancedtls.GetRootCAsParams{})
+		if err != nil {
+			t.Fatalf("GetRootCertificates() failed: %v", err)
+		}
+		if !results.TrustCerts.Equal(nil) && !poolsEqual(results.TrustCerts, ca1TrustPool(t, ca1PEM)) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("provider did not pick up rotated trust bundle before deadline")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func ca1TrustPool(t *testing.T, pemBytes []byte) *x509.CertPool {
+	t.Helper()
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		t.Fatalf("failed to parse ca1 pem")
+	}
+	return pool
+}
+
+func poolsEqual(a, b *x509.CertPool) bool {
+	return a.Equal(b)
+}
+
+// TestEndToEndServerUsesProvider is a light end-to-end check that a server
+// built from NewServerCreds with a Provider as its identity source serves
+// TLS connections correctly. The PEM files are not rotated in this test.
+func TestEndToEndServerUsesProvider(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeSelfSignedPEM(t, "server1.test", certFile, keyFile)
+
+	p, err := NewPEMFileProvider(PEMFileProviderOptions{
+		IdentityCertFile: certFile,
+		IdentityKeyFile:  keyFile,
+		RefreshDuration:  50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewPEMFileProvider() failed: %v", err)
+	}
+	defer p.Close()
+
+	creds, err := advancedtls.NewServerCreds(&advancedtls.ServerOptions{
+		IdentityOptions: advancedtls.IdentityCertificateOptions{
+			GetIdentityCertificatesForServer: p.GetIdentityCertificatesForServer,
+		},
+		VType: advancedtls.SkipVerification,
+	})
+	if err != nil {
+		t.Fatalf("NewServerCreds() failed: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen() failed: %v", err)
+	}
+	defer lis.Close()
+	s := grpc.NewServer(grpc.Creds(creds))
+	defer s.Stop()
+	go s.Serve(lis)
+
+	clientCreds, err := advancedtls.NewClientCreds(&advancedtls.ClientOptions{
+		VType:              advancedtls.SkipVerification,
+		ServerNameOverride: "server1.test",
+	})
+	if err != nil {
+		t.Fatalf("NewClientCreds() failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, lis.Addr().String(), grpc.WithTransportCredentials(clientCreds), grpc.WithBlock())
+	if err != nil {
+		t.Fatalf("failed to dial server: %v", err)
+	}
+	defer conn.Close()
+	if got, want := conn.GetState().String(), "READY"; got != want {
+		t.Fatalf("unexpected connection state: got %v, want %v", got, want)
+	}
+}
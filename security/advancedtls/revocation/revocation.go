@@ -0,0 +1,243 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package revocation provides a default, disk-backed CRL cache suitable for
+// use as advancedtls.RevocationConfig.CRLProvider.
+package revocation
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const defaultRefreshInterval = time.Hour
+
+// CacheOptions configures a Cache.
+type CacheOptions struct {
+	// Dir is the directory CRLs are cached in. It is REQUIRED, and created
+	// if it does not already exist.
+	Dir string
+	// RefreshInterval is how often the background goroutine checks cached
+	// CRLs for staleness against their NextUpdate field. Defaults to 1
+	// hour.
+	RefreshInterval time.Duration
+	// HTTPClient is used to fetch CRLDistributionPoints URLs. Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Cache is a disk-backed cache of CRLs, keyed by issuing certificate. It
+// fetches CRLs from a certificate's CRLDistributionPoints on first use, and
+// periodically refetches them in the background once their NextUpdate has
+// passed.
+type Cache struct {
+	dir             string
+	httpClient      *http.Client
+	refreshInterval time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+type cacheEntry struct {
+	crl *pkix.CertificateList
+	// issuer is kept around so the background refresh loop can refetch
+	// without needing the caller to ask again.
+	issuer *x509.Certificate
+}
+
+// NewCache creates a Cache rooted at opts.Dir and starts its background
+// refresh goroutine.
+func NewCache(opts CacheOptions) (*Cache, error) {
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("revocation: CacheOptions.Dir is required")
+	}
+	if err := os.MkdirAll(opts.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("revocation: failed to create cache dir %q: %v", opts.Dir, err)
+	}
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = defaultRefreshInterval
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	c := &Cache{
+		dir:             opts.Dir,
+		httpClient:      opts.HTTPClient,
+		refreshInterval: opts.RefreshInterval,
+		entries:         make(map[string]*cacheEntry),
+		closeCh:         make(chan struct{}),
+	}
+	c.wg.Add(1)
+	go c.run()
+	return c, nil
+}
+
+// Get returns the CRL covering certificates issued by issuer, the shape
+// expected of advancedtls.RevocationConfig.CRLProvider. It serves a fresh
+// on-disk or in-memory copy if NextUpdate has not passed, and otherwise
+// fetches a new one from issuer.CRLDistributionPoints.
+func (c *Cache) Get(issuer *x509.Certificate) (*pkix.CertificateList, error) {
+	key := cacheKey(issuer)
+
+	c.mu.Lock()
+	entry := c.entries[key]
+	c.mu.Unlock()
+	if entry != nil && time.Now().Before(entry.crl.TBSCertList.NextUpdate) {
+		return entry.crl, nil
+	}
+
+	if crl, err := c.loadFromDisk(key); err == nil && issuer.CheckCRLSignature(crl) == nil {
+		entry = &cacheEntry{crl: crl, issuer: issuer}
+		c.remember(key, issuer, crl)
+		if time.Now().Before(crl.TBSCertList.NextUpdate) {
+			return crl, nil
+		}
+	}
+
+	crl, err := c.fetch(issuer)
+	if err != nil {
+		// A stale CRL is still useful signal; only fail outright if we
+		// have never managed to load one at all.
+		if entry != nil {
+			return entry.crl, nil
+		}
+		return nil, err
+	}
+	return crl, nil
+}
+
+// Close stops the background refresh goroutine.
+func (c *Cache) Close() {
+	select {
+	case <-c.closeCh:
+	default:
+		close(c.closeCh)
+	}
+	c.wg.Wait()
+}
+
+func (c *Cache) run() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closeCh:
+			return
+		case <-ticker.C:
+			c.refreshStale()
+		}
+	}
+}
+
+func (c *Cache) refreshStale() {
+	c.mu.Lock()
+	stale := make([]*cacheEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		if time.Now().After(e.crl.TBSCertList.NextUpdate) {
+			stale = append(stale, e)
+		}
+	}
+	c.mu.Unlock()
+	for _, e := range stale {
+		if _, err := c.fetch(e.issuer); err != nil {
+			continue
+		}
+	}
+}
+
+func (c *Cache) fetch(issuer *x509.Certificate) (*pkix.CertificateList, error) {
+	if len(issuer.CRLDistributionPoints) == 0 {
+		return nil, fmt.Errorf("revocation: issuer %q has no CRLDistributionPoints", issuer.Subject)
+	}
+	var lastErr error
+	for _, url := range issuer.CRLDistributionPoints {
+		der, err := c.download(url)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		crl, err := x509.ParseCRL(der)
+		if err != nil {
+			lastErr = fmt.Errorf("revocation: failed to parse CRL from %q: %v", url, err)
+			continue
+		}
+		if err := issuer.CheckCRLSignature(crl); err != nil {
+			lastErr = fmt.Errorf("revocation: CRL from %q is not validly signed by issuer %q: %v", url, issuer.Subject, err)
+			continue
+		}
+		c.saveToDisk(cacheKey(issuer), der)
+		c.remember(cacheKey(issuer), issuer, crl)
+		return crl, nil
+	}
+	return nil, fmt.Errorf("revocation: failed to fetch CRL for issuer %q: %v", issuer.Subject, lastErr)
+}
+
+func (c *Cache) download(url string) ([]byte, error) {
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("revocation: failed to fetch CRL from %q: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("revocation: fetching CRL from %q returned status %d", url, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (c *Cache) remember(key string, issuer *x509.Certificate, crl *pkix.CertificateList) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = &cacheEntry{crl: crl, issuer: issuer}
+}
+
+func (c *Cache) diskPath(key string) string {
+	return filepath.Join(c.dir, key+".crl")
+}
+
+func (c *Cache) loadFromDisk(key string) (*pkix.CertificateList, error) {
+	der, err := os.ReadFile(c.diskPath(key))
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCRL(der)
+}
+
+func (c *Cache) saveToDisk(key string, der []byte) {
+	// Best-effort: a failure to persist just means the next process
+	// restart re-fetches instead of warming from disk.
+	_ = os.WriteFile(c.diskPath(key), der, 0600)
+}
+
+func cacheKey(issuer *x509.Certificate) string {
+	sum := sha256.Sum256(issuer.Raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,192 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package revocation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// genCA mints a self-signed CA certificate and key.
+func genCA(t *testing.T, commonName string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+	return ca, key
+}
+
+// genCRL mints a CRL issued by ca/caKey, revoking revoked, valid until
+// nextUpdate.
+func genCRL(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, revoked []pkix.RevokedCertificate, nextUpdate time.Time) (*pkix.CertificateList, []byte) {
+	t.Helper()
+	der, err := ca.CreateCRL(rand.Reader, caKey, revoked, time.Now(), nextUpdate)
+	if err != nil {
+		t.Fatalf("failed to create CRL: %v", err)
+	}
+	crl, err := x509.ParseCRL(der)
+	if err != nil {
+		t.Fatalf("failed to parse CRL: %v", err)
+	}
+	return crl, der
+}
+
+func newTestCache(t *testing.T, opts CacheOptions) *Cache {
+	t.Helper()
+	if opts.Dir == "" {
+		opts.Dir = t.TempDir()
+	}
+	c, err := NewCache(opts)
+	if err != nil {
+		t.Fatalf("NewCache() failed: %v", err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// TestCacheGetDiskHit checks that Get serves a fresh CRL straight from disk,
+// without needing to contact issuer.CRLDistributionPoints at all.
+func TestCacheGetDiskHit(t *testing.T) {
+	ca, caKey := genCA(t, "disk-hit-ca")
+	want, der := genCRL(t, ca, caKey, nil, time.Now().Add(time.Hour))
+
+	c := newTestCache(t, CacheOptions{})
+	if err := os.WriteFile(c.diskPath(cacheKey(ca)), der, 0600); err != nil {
+		t.Fatalf("failed to seed disk cache: %v", err)
+	}
+
+	got, err := c.Get(ca)
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Get() = %+v, want %+v", got, want)
+	}
+}
+
+// TestCacheGetDiskSignatureInvalidFallsBackToFetch checks that a disk-cached
+// CRL which does not validly verify against the issuer is rejected, and Get
+// falls back to fetching a fresh one from issuer.CRLDistributionPoints.
+func TestCacheGetDiskSignatureInvalidFallsBackToFetch(t *testing.T) {
+	ca, caKey := genCA(t, "disk-invalid-sig-ca")
+	attacker, attackerKey := genCA(t, "attacker-ca")
+	_, badDER := genCRL(t, attacker, attackerKey, nil, time.Now().Add(time.Hour))
+	want, goodDER := genCRL(t, ca, caKey, nil, time.Now().Add(time.Hour))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(goodDER)
+	}))
+	defer srv.Close()
+	ca.CRLDistributionPoints = []string{srv.URL}
+
+	c := newTestCache(t, CacheOptions{})
+	if err := os.WriteFile(c.diskPath(cacheKey(ca)), badDER, 0600); err != nil {
+		t.Fatalf("failed to seed disk cache: %v", err)
+	}
+
+	got, err := c.Get(ca)
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Get() = %+v, want %+v (the invalidly-signed disk copy should have been rejected)", got, want)
+	}
+}
+
+// TestCacheGetLiveFetchFailsFallsBackToStaleEntry checks that, when a stale
+// in-memory entry exists and a live refetch fails, Get still returns the
+// stale entry rather than an error.
+func TestCacheGetLiveFetchFailsFallsBackToStaleEntry(t *testing.T) {
+	ca, caKey := genCA(t, "live-fetch-fail-ca")
+	stale, _ := genCRL(t, ca, caKey, nil, time.Now().Add(-time.Minute))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	ca.CRLDistributionPoints = []string{srv.URL}
+
+	c := newTestCache(t, CacheOptions{})
+	c.remember(cacheKey(ca), ca, stale)
+
+	got, err := c.Get(ca)
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, stale) {
+		t.Fatalf("Get() = %+v, want stale entry %+v", got, stale)
+	}
+}
+
+// TestCacheRefreshStaleRefetches checks that refreshStale replaces a stale
+// in-memory entry with a freshly fetched CRL.
+func TestCacheRefreshStaleRefetches(t *testing.T) {
+	ca, caKey := genCA(t, "refresh-ca")
+	stale, _ := genCRL(t, ca, caKey, nil, time.Now().Add(-time.Minute))
+	_, freshDER := genCRL(t, ca, caKey, nil, time.Now().Add(time.Hour))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(freshDER)
+	}))
+	defer srv.Close()
+	ca.CRLDistributionPoints = []string{srv.URL}
+
+	c := newTestCache(t, CacheOptions{RefreshInterval: time.Hour})
+	c.remember(cacheKey(ca), ca, stale)
+
+	c.refreshStale()
+
+	c.mu.Lock()
+	entry := c.entries[cacheKey(ca)]
+	c.mu.Unlock()
+	if entry == nil {
+		t.Fatalf("entry missing after refreshStale()")
+	}
+	if !entry.crl.TBSCertList.NextUpdate.After(time.Now()) {
+		t.Fatalf("refreshStale() did not refresh the stale entry, NextUpdate = %v", entry.crl.TBSCertList.NextUpdate)
+	}
+}
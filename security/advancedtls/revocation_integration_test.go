@@ -0,0 +1,297 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package advancedtls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+	"google.golang.org/grpc"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+)
+
+// genCA mints a self-signed CA certificate and key.
+func genCA(t *testing.T, commonName string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+	return ca, key
+}
+
+// genLeaf mints a leaf certificate signed by ca/caKey.
+func genLeaf(t *testing.T, commonName string, serial *big.Int, ca *x509.Certificate, caKey *ecdsa.PrivateKey) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate leaf key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create leaf certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse leaf certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// TestRevocationCRLRejectsRevokedPeer exercises RevocationConfig.CRLProvider:
+// at stage 0, the client's CRLProvider reports no revocations and RPCs
+// succeed, including over a connection that stays open across the
+// transition. At stage 1, a CRL revoking the server's leaf certificate is
+// "published" (the fake CRLProvider starts returning it), and new
+// handshakes are rejected, while the already-established connection from
+// stage 0 keeps working.
+func (s) TestRevocationCRLRejectsRevokedPeer(t *testing.T) {
+	ca, caKey := genCA(t, "revocation-test-ca")
+	leaf := genLeaf(t, "server.revocation.test", big.NewInt(42), ca, caKey)
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca)
+
+	serverCreds, err := NewServerCreds(&ServerOptions{
+		IdentityOptions: IdentityCertificateOptions{Certificates: []tls.Certificate{leaf}},
+		VType:           SkipVerification,
+	})
+	if err != nil {
+		t.Fatalf("failed to create server creds: %v", err)
+	}
+	lis, err := net.Listen("tcp", port)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer lis.Close()
+	s := grpc.NewServer(grpc.Creds(serverCreds))
+	defer s.Stop()
+	pb.RegisterGreeterServer(s, greeterServer{})
+	go s.Serve(lis)
+
+	var revoked int32
+	crlProvider := func(issuer *x509.Certificate) (*pkix.CertificateList, error) {
+		var revokedCerts []pkix.RevokedCertificate
+		if atomic.LoadInt32(&revoked) != 0 {
+			revokedCerts = []pkix.RevokedCertificate{
+				{SerialNumber: leaf.Leaf.SerialNumber, RevocationTime: time.Now()},
+			}
+		}
+		der, err := ca.CreateCRL(rand.Reader, caKey, revokedCerts, time.Now(), time.Now().Add(time.Hour))
+		if err != nil {
+			return nil, err
+		}
+		return x509.ParseCRL(der)
+	}
+	clientCreds, err := NewClientCreds(&ClientOptions{
+		RootOptions: RootCertificateOptions{RootCACerts: caPool},
+		VerifyPeer: func(params *VerificationFuncParams) (*VerificationResults, error) {
+			return &VerificationResults{}, nil
+		},
+		VType:             CertVerification,
+		RevocationOptions: &RevocationConfig{CRLProvider: crlProvider},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client creds: %v", err)
+	}
+
+	// Stage 0: no revocation published yet, connection should succeed and
+	// stay up across the transition below.
+	ctx0, cancel0 := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel0()
+	longLivedConn, greetClient, err := callAndVerifyWithClientConn(ctx0, "rpc call before revocation", clientCreds, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer longLivedConn.Close()
+
+	// Stage 1: the CRL now revokes the server's leaf certificate.
+	atomic.StoreInt32(&revoked, 1)
+
+	// The already-established connection is unaffected; its handshake
+	// already completed.
+	if err := callAndVerify("rpc call on existing conn after revocation", greetClient, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// A brand new connection must have its handshake rejected.
+	ctx1, cancel1 := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel1()
+	newConn, _, err := callAndVerifyWithClientConn(ctx1, "rpc call after revocation", clientCreds, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer newConn.Close()
+}
+
+// TestRevocationStapledOCSPRejectsRevokedPeer exercises
+// RevocationConfig.AllowStapledOCSP: the server staples an OCSP response
+// marking its own leaf certificate revoked, and a client with
+// AllowStapledOCSP set must reject the resulting handshake.
+func (s) TestRevocationStapledOCSPRejectsRevokedPeer(t *testing.T) {
+	ca, caKey := genCA(t, "stapled-ocsp-test-ca")
+	leaf := genLeaf(t, "server.stapled-ocsp.test", big.NewInt(7), ca, caKey)
+
+	staple, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+		Status:       ocsp.Revoked,
+		SerialNumber: leaf.Leaf.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+	}, caKey)
+	if err != nil {
+		t.Fatalf("failed to create stapled OCSP response: %v", err)
+	}
+	leaf.OCSPStaple = staple
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca)
+
+	serverCreds, err := NewServerCreds(&ServerOptions{
+		IdentityOptions: IdentityCertificateOptions{Certificates: []tls.Certificate{leaf}},
+		VType:           SkipVerification,
+	})
+	if err != nil {
+		t.Fatalf("failed to create server creds: %v", err)
+	}
+	lis, err := net.Listen("tcp", port)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer lis.Close()
+	s := grpc.NewServer(grpc.Creds(serverCreds))
+	defer s.Stop()
+	pb.RegisterGreeterServer(s, greeterServer{})
+	go s.Serve(lis)
+
+	clientCreds, err := NewClientCreds(&ClientOptions{
+		RootOptions: RootCertificateOptions{RootCACerts: caPool},
+		VerifyPeer: func(params *VerificationFuncParams) (*VerificationResults, error) {
+			return &VerificationResults{}, nil
+		},
+		VType:             CertVerification,
+		RevocationOptions: &RevocationConfig{AllowStapledOCSP: true},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client creds: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := callAndVerifyWithClientConn(ctx, "rpc call with revoked stapled OCSP response", clientCreds, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+}
+
+// TestRevocationStapledOCSPAcceptsGoodPeer is the good-staple counterpart to
+// TestRevocationStapledOCSPRejectsRevokedPeer: with the same
+// RevocationConfig{AllowStapledOCSP: true} and no other revocation source
+// configured, a server stapling an ocsp.Good response for its own leaf
+// certificate must be accepted. Without this case, the rejection above could
+// pass for the wrong reason (e.g. AllowStapledOCSP-only configs being
+// rejected unconditionally) without being caught.
+func (s) TestRevocationStapledOCSPAcceptsGoodPeer(t *testing.T) {
+	ca, caKey := genCA(t, "stapled-ocsp-good-test-ca")
+	leaf := genLeaf(t, "server.stapled-ocsp-good.test", big.NewInt(8), ca, caKey)
+
+	staple, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: leaf.Leaf.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+	}, caKey)
+	if err != nil {
+		t.Fatalf("failed to create stapled OCSP response: %v", err)
+	}
+	leaf.OCSPStaple = staple
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca)
+
+	serverCreds, err := NewServerCreds(&ServerOptions{
+		IdentityOptions: IdentityCertificateOptions{Certificates: []tls.Certificate{leaf}},
+		VType:           SkipVerification,
+	})
+	if err != nil {
+		t.Fatalf("failed to create server creds: %v", err)
+	}
+	lis, err := net.Listen("tcp", port)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer lis.Close()
+	s := grpc.NewServer(grpc.Creds(serverCreds))
+	defer s.Stop()
+	pb.RegisterGreeterServer(s, greeterServer{})
+	go s.Serve(lis)
+
+	clientCreds, err := NewClientCreds(&ClientOptions{
+		RootOptions: RootCertificateOptions{RootCACerts: caPool},
+		VerifyPeer: func(params *VerificationFuncParams) (*VerificationResults, error) {
+			return &VerificationResults{}, nil
+		},
+		VType:             CertVerification,
+		RevocationOptions: &RevocationConfig{AllowStapledOCSP: true},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client creds: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := callAndVerifyWithClientConn(ctx, "rpc call with good stapled OCSP response", clientCreds, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+}
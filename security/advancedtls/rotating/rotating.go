@@ -0,0 +1,222 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package rotating provides a RotatingIdentityProvider, an identity
+// certificate source that keeps itself fresh by renewing ahead of expiry.
+package rotating
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRenewAtFraction = 2.0 / 3.0
+	initialRenewBackoff    = time.Second
+	maxRenewBackoff        = time.Minute
+)
+
+// RotatingOptions configures a RotatingIdentityProvider.
+type RotatingOptions struct {
+	// InitialCert is the identity certificate served until the first
+	// successful renewal.
+	InitialCert *tls.Certificate
+	// Renew is called to obtain a replacement for current, typically by
+	// talking to a CA/ACME/SPIRE endpoint. It is REQUIRED.
+	Renew func(ctx context.Context, current *tls.Certificate) (*tls.Certificate, error)
+	// RenewBefore, if set, schedules renewal RenewBefore before the
+	// current certificate's NotAfter. Takes precedence over
+	// RenewAtFraction.
+	RenewBefore time.Duration
+	// RenewAtFraction, if set, schedules renewal at
+	// NotBefore + (NotAfter-NotBefore)*RenewAtFraction. Defaults to 2/3,
+	// mirroring the renewal point step-ca's bootstrap clients use.
+	RenewAtFraction float64
+}
+
+// RotatingIdentityProvider serves an identity certificate that it keeps
+// fresh by calling RotatingOptions.Renew shortly before the certificate's
+// remaining lifetime runs out. It implements the
+// GetIdentityCertificatesForClient/GetIdentityCertificatesForServer callback
+// shapes, so it can be plugged directly into
+// advancedtls.IdentityCertificateOptions.
+type RotatingIdentityProvider struct {
+	opts RotatingOptions
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	subs []func(*tls.Certificate)
+
+	closeCh chan struct{}
+	closeWg sync.WaitGroup
+}
+
+// NewRotatingIdentityProvider creates a RotatingIdentityProvider serving
+// opts.InitialCert, and starts a background goroutine that renews it ahead
+// of expiry for as long as the provider is open.
+func NewRotatingIdentityProvider(opts RotatingOptions) (*RotatingIdentityProvider, error) {
+	if opts.InitialCert == nil {
+		return nil, fmt.Errorf("rotating: RotatingOptions.InitialCert is required")
+	}
+	if opts.Renew == nil {
+		return nil, fmt.Errorf("rotating: RotatingOptions.Renew is required")
+	}
+	if opts.RenewAtFraction <= 0 {
+		opts.RenewAtFraction = defaultRenewAtFraction
+	}
+	if _, err := leafOf(opts.InitialCert); err != nil {
+		return nil, err
+	}
+	p := &RotatingIdentityProvider{
+		opts:    opts,
+		cert:    opts.InitialCert,
+		closeCh: make(chan struct{}),
+	}
+	p.closeWg.Add(1)
+	go p.run()
+	return p, nil
+}
+
+func leafOf(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, fmt.Errorf("rotating: certificate has no DER bytes")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("rotating: failed to parse certificate: %v", err)
+	}
+	return leaf, nil
+}
+
+func (p *RotatingIdentityProvider) renewAt(leaf *x509.Certificate) time.Time {
+	if p.opts.RenewBefore > 0 {
+		return leaf.NotAfter.Add(-p.opts.RenewBefore)
+	}
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	return leaf.NotBefore.Add(time.Duration(float64(lifetime) * p.opts.RenewAtFraction))
+}
+
+func (p *RotatingIdentityProvider) run() {
+	defer p.closeWg.Done()
+	backoff := initialRenewBackoff
+	for {
+		current := p.KeyMaterial()
+		leaf, err := leafOf(current)
+		if err != nil {
+			// Should not happen: every cert we accept is validated by
+			// leafOf in NewRotatingIdentityProvider/run. Retry later
+			// rather than busy-looping.
+			if !p.sleep(maxRenewBackoff) {
+				return
+			}
+			continue
+		}
+		wait := time.Until(p.renewAt(leaf))
+		if wait > 0 {
+			if !p.sleep(wait) {
+				return
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), maxRenewBackoff)
+		next, err := p.opts.Renew(ctx, current)
+		cancel()
+		if err != nil {
+			if !p.sleep(backoff) {
+				return
+			}
+			backoff *= 2
+			if backoff > maxRenewBackoff {
+				backoff = maxRenewBackoff
+			}
+			continue
+		}
+		backoff = initialRenewBackoff
+		if _, err := leafOf(next); err != nil {
+			if !p.sleep(backoff) {
+				return
+			}
+			continue
+		}
+		p.mu.Lock()
+		p.cert = next
+		subs := append([]func(*tls.Certificate){}, p.subs...)
+		p.mu.Unlock()
+		for _, sub := range subs {
+			sub(next)
+		}
+	}
+}
+
+// sleep blocks for d, or until Close is called, whichever comes first. It
+// returns false if Close fired.
+func (p *RotatingIdentityProvider) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-p.closeCh:
+		return false
+	}
+}
+
+// KeyMaterial returns the most recently renewed certificate.
+func (p *RotatingIdentityProvider) KeyMaterial() *tls.Certificate {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.cert
+}
+
+// Subscribe registers f to be called, with the new certificate, every time
+// a renewal succeeds. f is called synchronously from the provider's
+// background goroutine, so it should not block.
+func (p *RotatingIdentityProvider) Subscribe(f func(*tls.Certificate)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subs = append(p.subs, f)
+}
+
+// Close stops the background renewal goroutine. It is safe to call Close
+// more than once.
+func (p *RotatingIdentityProvider) Close() {
+	select {
+	case <-p.closeCh:
+	default:
+		close(p.closeCh)
+	}
+	p.closeWg.Wait()
+}
+
+// GetIdentityCertificatesForClient is suitable for use as
+// advancedtls.IdentityCertificateOptions.GetIdentityCertificatesForClient.
+func (p *RotatingIdentityProvider) GetIdentityCertificatesForClient(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return p.KeyMaterial(), nil
+}
+
+// GetIdentityCertificatesForServer is suitable for use as
+// advancedtls.IdentityCertificateOptions.GetIdentityCertificatesForServer.
+func (p *RotatingIdentityProvider) GetIdentityCertificatesForServer(*tls.ClientHelloInfo) ([]*tls.Certificate, error) {
+	return []*tls.Certificate{p.KeyMaterial()}, nil
+}
@@ -0,0 +1,178 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rotating
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/security/advancedtls"
+)
+
+type greeterServer struct {
+	pb.UnimplementedGreeterServer
+}
+
+// sayHello is a simple implementation of the pb.GreeterServer SayHello method.
+func (greeterServer) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	return &pb.HelloReply{Message: "Hello " + in.Name}, nil
+}
+
+func callAndVerify(msg string, client pb.GreeterClient, shouldFail bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := client.SayHello(ctx, &pb.HelloRequest{Name: msg})
+	if want, got := shouldFail == true, err != nil; got != want {
+		return fmt.Errorf("want and got mismatch,  want shouldFail=%v, got fail=%v, rpc error: %v", want, got, err)
+	}
+	return nil
+}
+
+// genSelfSignedCert mints a short-lived, self-signed identity certificate
+// for commonName, valid for lifetime starting now.
+func genSelfSignedCert(t *testing.T, commonName string, lifetime time.Duration) *tls.Certificate {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now,
+		NotAfter:     now.Add(lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv, Leaf: leaf}
+}
+
+// TestRotatingIdentityProviderEndToEnd fakes a CA that always reissues
+// "rotated" once the initial, short-lived certificate nears expiry, and
+// checks that new connections pick up the rotated certificate while a
+// connection established before the rotation keeps working.
+func TestRotatingIdentityProviderEndToEnd(t *testing.T) {
+	initial := genSelfSignedCert(t, "initial", 200*time.Millisecond)
+	rotated := genSelfSignedCert(t, "rotated", time.Hour)
+
+	var renewCalls int32
+	rotatedCh := make(chan struct{})
+	provider, err := NewRotatingIdentityProvider(RotatingOptions{
+		InitialCert:     initial,
+		RenewAtFraction: 0.5,
+		Renew: func(ctx context.Context, current *tls.Certificate) (*tls.Certificate, error) {
+			atomic.AddInt32(&renewCalls, 1)
+			return rotated, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewRotatingIdentityProvider() failed: %v", err)
+	}
+	defer provider.Close()
+	provider.Subscribe(func(*tls.Certificate) { close(rotatedCh) })
+
+	creds, err := advancedtls.NewServerCreds(&advancedtls.ServerOptions{
+		IdentityOptions: advancedtls.IdentityCertificateOptions{
+			GetIdentityCertificatesForServer: provider.GetIdentityCertificatesForServer,
+		},
+		VType: advancedtls.SkipVerification,
+	})
+	if err != nil {
+		t.Fatalf("NewServerCreds() failed: %v", err)
+	}
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen() failed: %v", err)
+	}
+	defer lis.Close()
+	s := grpc.NewServer(grpc.Creds(creds))
+	defer s.Stop()
+	pb.RegisterGreeterServer(s, greeterServer{})
+	go s.Serve(lis)
+
+	dial := func() *grpc.ClientConn {
+		clientCreds, err := advancedtls.NewClientCreds(&advancedtls.ClientOptions{VType: advancedtls.SkipVerification})
+		if err != nil {
+			t.Fatalf("NewClientCreds() failed: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, lis.Addr().String(), grpc.WithTransportCredentials(clientCreds), grpc.WithBlock())
+		if err != nil {
+			t.Fatalf("failed to dial server: %v", err)
+		}
+		return conn
+	}
+
+	// Connection established before rotation.
+	preRotationConn := dial()
+	defer preRotationConn.Close()
+	if err := callAndVerify("before rotation", pb.NewGreeterClient(preRotationConn), false); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-rotatedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("provider did not rotate before deadline")
+	}
+	if got := provider.KeyMaterial().Leaf.Subject.CommonName; got != "rotated" {
+		t.Fatalf("provider.KeyMaterial() CommonName = %q, want %q", got, "rotated")
+	}
+
+	// New connection established after rotation.
+	postRotationConn := dial()
+	defer postRotationConn.Close()
+	if err := callAndVerify("after rotation", pb.NewGreeterClient(postRotationConn), false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pre-rotation connection's existing stream is unaffected by the
+	// server's identity certificate changing underneath it.
+	if err := callAndVerify("still alive", pb.NewGreeterClient(preRotationConn), false); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := atomic.LoadInt32(&renewCalls); calls == 0 {
+		t.Fatalf("Renew was never called")
+	}
+}